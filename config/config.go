@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// AppConfig holds the subset of process configuration the streamer and its
+// pipes/locks need at runtime. A single instance is loaded at startup and
+// handed around explicitly (most callers take a *AppConfig) or fetched
+// through Get() where threading it through isn't practical
+type AppConfig struct {
+	//PulsarAddr is the Pulsar broker URL (e.g. "pulsar://localhost:6650")
+	//used by the Pulsar pipe implementation
+	PulsarAddr string
+
+	//LockBackend selects the lock.Lock implementation lock.Create builds,
+	//by the name it was registered under (e.g. "mysql", "etcd"). Empty
+	//defaults to the MySQL state DB backend
+	LockBackend string
+
+	//LockEtcdAddr is the list of etcd endpoints the etcd lock backend
+	//dials, ignored by backends that don't use etcd
+	LockEtcdAddr []string
+
+	//LockEtcdPrefix namespaces this deployment's keys under the shared
+	//etcd cluster, ignored by backends that don't use etcd
+	LockEtcdPrefix string
+
+	//LockMysqlEnableMDLInstrument opts the MySQL lock backend into enabling
+	//the performance_schema.setup_instruments row Count needs on every
+	//worker startup. It's a global, server-wide toggle, so deployments that
+	//already flip it once via a migration/ops step should leave this false
+	LockMysqlEnableMDLInstrument bool
+
+	//HeartbeatInterval is how often waitForGtid emits a heartbeat event
+	//while waiting for a target Gtid to catch up. Zero disables heartbeats
+	HeartbeatInterval time.Duration
+}
+
+var current *AppConfig
+
+//Get returns the process-wide AppConfig set by Set, for callers that can't
+//take a *AppConfig as a parameter
+func Get() *AppConfig {
+	return current
+}
+
+//Set installs cfg as the process-wide AppConfig returned by Get
+func Set(cfg *AppConfig) {
+	current = cfg
+}
+
+//GetOutputTopicName returns the topic a streamer should produce row/schema
+//events for svc/db/table to, versioned so consumers can tell events
+//produced under different schema versions apart
+func (c *AppConfig) GetOutputTopicName(svc string, db string, table string, input string, output string, version int) (string, error) {
+	return fmt.Sprintf("%s-%s-%s-%s-%s-v%d", svc, db, table, input, output, version), nil
+}
+
+//GetChangelogTopicName returns the topic a streamer reads upstream
+//changelog events from for svc/db/table, versioned the same way as
+//GetOutputTopicName
+func (c *AppConfig) GetChangelogTopicName(svc string, db string, table string, input string, output string, version int) (string, error) {
+	return fmt.Sprintf("%s-%s-%s-%s-%s-changelog-v%d", svc, db, table, input, output, version), nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoder
+
+import (
+	"fmt"
+	"time"
+)
+
+// Encoder turns row events into the wire format a pipe's producer sends,
+// and also carries the envelope-level marker events (schema changes,
+// heartbeats) that ride alongside regular row events on the same topic
+type Encoder interface {
+	Type() string
+	EncodeRow(op string, row map[string]interface{}) ([]byte, error)
+	EncodeSchemaChange(fromVersion int, toVersion int) ([]byte, error)
+	EncodeHeartbeat(currentGtid string, targetGtid string, version int, wallTS time.Time) ([]byte, error)
+}
+
+// Format identifies an encoding by name, e.g. "json" or the internal
+// envelope format
+type Format string
+
+// Type returns the format name, satisfying the same accessor Encoder uses
+func (f Format) Type() string {
+	return string(f)
+}
+
+// Internal is the transit/envelope format used between the binlog reader
+// and the streamer, as opposed to svc/db/table's own output format
+const Internal Format = "internal"
+
+type ctor func(svc string, db string, table string, version int) (Encoder, error)
+
+var registry = map[string]ctor{}
+
+func registerFormat(name string, fn ctor) {
+	registry[name] = fn
+}
+
+// Create builds the Encoder for format, scoped to a single svc.db.table at
+// the given schema version
+func Create(format string, svc string, db string, table string, version int) (Encoder, error) {
+	fn, ok := registry[format]
+	if !ok {
+		return nil, fmt.Errorf("Unknown encoder format: %v", format)
+	}
+	return fn(svc, db, table, version)
+}
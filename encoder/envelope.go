@@ -0,0 +1,120 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoder
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// tableEncoder is the shared Encoder implementation for both the internal
+// envelope format and the plain output formats registered below
+type tableEncoder struct {
+	format  string
+	svc     string
+	db      string
+	table   string
+	version int
+}
+
+func newTableEncoder(format string) ctor {
+	return func(svc string, db string, table string, version int) (Encoder, error) {
+		return &tableEncoder{format: format, svc: svc, db: db, table: table, version: version}, nil
+	}
+}
+
+func (e *tableEncoder) Type() string {
+	return e.format
+}
+
+// EncodeRow marshals a single row change (op is "insert", "update" or
+// "delete") for the table's output topic, at the schema version this
+// encoder was created for
+func (e *tableEncoder) EncodeRow(op string, row map[string]interface{}) ([]byte, error) {
+	return json.Marshal(struct {
+		Type    string                 `json:"type"`
+		Svc     string                 `json:"svc"`
+		Db      string                 `json:"db"`
+		Table   string                 `json:"table"`
+		Version int                    `json:"version"`
+		Op      string                 `json:"op"`
+		Row     map[string]interface{} `json:"row"`
+	}{
+		Type:    "row",
+		Svc:     e.svc,
+		Db:      e.db,
+		Table:   e.table,
+		Version: e.version,
+		Op:      op,
+		Row:     row,
+	})
+}
+
+// EncodeSchemaChange marshals a schema-change marker event, emitted onto the
+// output topic when a running streamer switches to a new table version, for
+// the svc/db/table this encoder was created for
+func (e *tableEncoder) EncodeSchemaChange(fromVersion int, toVersion int) ([]byte, error) {
+	return json.Marshal(struct {
+		Type        string `json:"type"`
+		Svc         string `json:"svc"`
+		Db          string `json:"db"`
+		Table       string `json:"table"`
+		FromVersion int    `json:"from_version"`
+		ToVersion   int    `json:"to_version"`
+	}{
+		Type:        "schema_change",
+		Svc:         e.svc,
+		Db:          e.db,
+		Table:       e.table,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+	})
+}
+
+// EncodeHeartbeat marshals a heartbeat event, emitted while waitForGtid is
+// stalled so consumers can tell "alive but waiting" from a dead streamer,
+// for the svc/db/table this encoder was created for
+func (e *tableEncoder) EncodeHeartbeat(currentGtid string, targetGtid string, version int, wallTS time.Time) ([]byte, error) {
+	return json.Marshal(struct {
+		Type        string `json:"type"`
+		Svc         string `json:"svc"`
+		Db          string `json:"db"`
+		Table       string `json:"table"`
+		CurrentGtid string `json:"current_gtid"`
+		TargetGtid  string `json:"target_gtid"`
+		Version     int    `json:"version"`
+		WallTS      int64  `json:"wall_ts"`
+	}{
+		Type:        "heartbeat",
+		Svc:         e.svc,
+		Db:          e.db,
+		Table:       e.table,
+		CurrentGtid: currentGtid,
+		TargetGtid:  targetGtid,
+		Version:     version,
+		WallTS:      wallTS.Unix(),
+	})
+}
+
+func init() {
+	registerFormat(Internal.Type(), newTableEncoder(Internal.Type()))
+	registerFormat("json", newTableEncoder("json"))
+}
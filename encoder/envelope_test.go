@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCreateUnknownFormat(t *testing.T) {
+	if _, err := Create("does-not-exist", "svc", "db", "t", 1); err == nil {
+		t.Error("Create with an unregistered format should return an error")
+	}
+}
+
+func TestEncodeSchemaChange(t *testing.T) {
+	e, err := Create(Internal.Type(), "svc", "db", "t", 3)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	buf, err := e.EncodeSchemaChange(3, 4)
+	if err != nil {
+		t.Fatalf("EncodeSchemaChange: %v", err)
+	}
+
+	var evt map[string]interface{}
+	if err := json.Unmarshal(buf, &evt); err != nil {
+		t.Fatalf("EncodeSchemaChange produced invalid JSON: %v", err)
+	}
+
+	if evt["type"] != "schema_change" || evt["from_version"] != float64(3) || evt["to_version"] != float64(4) {
+		t.Errorf("EncodeSchemaChange produced unexpected event: %v", evt)
+	}
+}
+
+func TestEncodeRow(t *testing.T) {
+	e, err := Create(Internal.Type(), "svc", "db", "t", 3)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	buf, err := e.EncodeRow("insert", map[string]interface{}{"id": float64(1)})
+	if err != nil {
+		t.Fatalf("EncodeRow: %v", err)
+	}
+
+	var evt map[string]interface{}
+	if err := json.Unmarshal(buf, &evt); err != nil {
+		t.Fatalf("EncodeRow produced invalid JSON: %v", err)
+	}
+
+	row, _ := evt["row"].(map[string]interface{})
+	if evt["type"] != "row" || evt["op"] != "insert" || evt["version"] != float64(3) || row["id"] != float64(1) {
+		t.Errorf("EncodeRow produced unexpected event: %v", evt)
+	}
+}
+
+func TestEncodeHeartbeat(t *testing.T) {
+	e, err := Create(Internal.Type(), "svc", "db", "t", 3)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	ts := time.Unix(1700000000, 0)
+	buf, err := e.EncodeHeartbeat("gtid-1", "gtid-2", 3, ts)
+	if err != nil {
+		t.Fatalf("EncodeHeartbeat: %v", err)
+	}
+
+	var evt map[string]interface{}
+	if err := json.Unmarshal(buf, &evt); err != nil {
+		t.Fatalf("EncodeHeartbeat produced invalid JSON: %v", err)
+	}
+
+	if evt["type"] != "heartbeat" || evt["current_gtid"] != "gtid-1" || evt["target_gtid"] != "gtid-2" || evt["wall_ts"] != float64(ts.Unix()) {
+		t.Errorf("EncodeHeartbeat produced unexpected event: %v", evt)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package lock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+//etcdLeaseTTL is the lease TTL backing every key this lock takes, refreshed
+//via KeepAlive for as long as the worker holding it is alive
+const etcdLeaseTTL = 10 //seconds
+
+//etcdLock is a lock.Lock backed by an etcd lease-based ephemeral key
+type etcdLock struct {
+	cli    *clientv3.Client
+	prefix string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	key     string
+	leaseID clientv3.LeaseID
+	keepAlive <-chan *clientv3.LeaseKeepAliveResponse
+}
+
+//newEtcdLock dials the etcd endpoints and returns a Lock that namespaces
+//all keys under prefix, so multiple deployments can share a cluster
+func newEtcdLock(endpoints []string, prefix string) (Lock, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &etcdLock{cli: cli, prefix: prefix, ctx: ctx, cancel: cancel}, nil
+}
+
+//TryLock attempts to create path under the configured prefix bound to a
+//fresh lease, returning false without blocking if the key is already held
+func (e *etcdLock) TryLock(path string) bool {
+	key := e.prefix + path
+
+	lease, err := e.cli.Grant(e.ctx, etcdLeaseTTL)
+	if log.E(err) {
+		return false
+	}
+
+	//Put only if the key does not exist yet, this is what makes the lock exclusive
+	txn := e.cli.Txn(e.ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, "", clientv3.WithLease(lease.ID))).
+		Else()
+
+	resp, err := txn.Commit()
+	if log.E(err) {
+		return false
+	}
+	if !resp.Succeeded {
+		//Nobody will hold this key with the lease, revoke it now instead of
+		//waiting out etcdLeaseTTL on every failed probe
+		_, err = e.cli.Revoke(e.ctx, lease.ID)
+		log.E(err)
+		return false
+	}
+
+	keepAlive, err := e.cli.KeepAlive(e.ctx, lease.ID)
+	if log.E(err) {
+		return false
+	}
+
+	e.key = key
+	e.leaseID = lease.ID
+	e.keepAlive = keepAlive
+
+	return true
+}
+
+//Refresh takes at most one pending keepalive response without blocking
+//and reports false once the keepalive channel has been closed (the lease
+//expired or was revoked); with no response pending it reports true
+func (e *etcdLock) Refresh() bool {
+	select {
+	case ka, ok := <-e.keepAlive:
+		return ok && ka != nil
+	default:
+		return true
+	}
+}
+
+//Unlock revokes the lease, which atomically deletes the key this lock
+//was holding along with any other key sharing the lease
+func (e *etcdLock) Unlock() {
+	_, err := e.cli.Revoke(e.ctx, e.leaseID)
+	log.E(err)
+}
+
+//Count returns the number of keys currently held under prefix, letting
+//callers peek at remaining capacity without spending a TryLock attempt.
+//Keys are counted if they equal prefix exactly or sit under prefix+".",
+//not merely start with prefix as a bare string, so a cluster named e.g.
+//"east" doesn't also count keys held by a cluster named "east2"
+func (e *etcdLock) Count(prefix string) (int, error) {
+	key := e.prefix + prefix
+
+	exact, err := e.cli.Get(e.ctx, key, clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+
+	nested, err := e.cli.Get(e.ctx, key+".", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+
+	return int(exact.Count + nested.Count), nil
+}
+
+//Close stops the keepalive goroutine and closes the etcd client
+func (e *etcdLock) Close() error {
+	e.cancel()
+	return e.cli.Close()
+}
+
+func init() {
+	registerBackend("etcd", func(c lockConfig) (Lock, error) {
+		if len(c.Endpoints) == 0 {
+			return nil, fmt.Errorf("etcd lock backend requires at least one endpoint")
+		}
+		return newEtcdLock(c.Endpoints, c.Prefix)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package lock
+
+import (
+	"github.com/uber/storagetapper/config"
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/state"
+)
+
+// Lock is a distributed lock backend used by the streamer to coordinate
+// which worker handles a given table or cluster
+type Lock interface {
+	TryLock(path string) bool
+	Unlock()
+	Refresh() bool
+	Close() error
+}
+
+// Counter is implemented by backends that can report how many keys under a
+// prefix are currently held, so callers can peek at remaining capacity
+// before spending a TryLock attempt on it. Not every backend supports this
+// cheaply, so callers should fall back gracefully when a Lock isn't one
+type Counter interface {
+	Count(prefix string) (int, error)
+}
+
+// lockConfig carries the subset of config.AppConfig a backend constructor needs
+type lockConfig struct {
+	Addr                string
+	Endpoints           []string
+	Prefix              string
+	Concurrency         int
+	EnableMDLInstrument bool
+}
+
+type backendFunc func(lockConfig) (Lock, error)
+
+var backends = map[string]backendFunc{}
+
+// registerBackend makes a Lock implementation selectable via cfg.LockBackend
+func registerBackend(name string, fn backendFunc) {
+	backends[name] = fn
+}
+
+// defaultBackend preserves existing behavior for deployments that don't set
+// cfg.LockBackend
+const defaultBackend = "mysql"
+
+// Create builds a Lock using the backend selected by cfg.LockBackend,
+// defaulting to the MySQL state DB backend for backward compatibility
+func Create(cfg *config.AppConfig, concurrency int) Lock {
+	name := cfg.LockBackend
+	if name == "" {
+		name = defaultBackend
+	}
+
+	fn, ok := backends[name]
+	if !ok {
+		log.Fatalf("Unknown lock backend: %v", name)
+	}
+
+	l, err := fn(lockConfig{
+		Addr:                state.GetDbAddr(),
+		Endpoints:           cfg.LockEtcdAddr,
+		Prefix:              cfg.LockEtcdPrefix,
+		Concurrency:         concurrency,
+		EnableMDLInstrument: cfg.LockMysqlEnableMDLInstrument,
+	})
+	if log.E(err) {
+		log.Fatalf("Failed to create %v lock: %v", name, err)
+	}
+
+	return l
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package lock
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/uber/storagetapper/config"
+)
+
+type fakeLock struct{ name string }
+
+func (f *fakeLock) TryLock(string) bool { return true }
+func (f *fakeLock) Unlock()             {}
+func (f *fakeLock) Refresh() bool       { return true }
+func (f *fakeLock) Close() error        { return nil }
+
+func withFakeBackend(name string, t *testing.T) *fakeLock {
+	t.Helper()
+
+	var got *fakeLock
+	registerBackend(name, func(lockConfig) (Lock, error) {
+		got = &fakeLock{name: name}
+		return got, nil
+	})
+	t.Cleanup(func() { delete(backends, name) })
+
+	return got
+}
+
+func TestCreateDefaultsToMysqlBackend(t *testing.T) {
+	withFakeBackend("mysql", t)
+
+	l := Create(&config.AppConfig{}, 1)
+	if f, ok := l.(*fakeLock); !ok || f.name != "mysql" {
+		t.Errorf("Create with no LockBackend set should use the mysql backend, got %#v", l)
+	}
+}
+
+func TestCreateSelectsConfiguredBackend(t *testing.T) {
+	withFakeBackend("mysql", t)
+	withFakeBackend("etcd", t)
+
+	l := Create(&config.AppConfig{LockBackend: "etcd"}, 1)
+	if f, ok := l.(*fakeLock); !ok || f.name != "etcd" {
+		t.Errorf("Create with LockBackend=etcd should use the etcd backend, got %#v", l)
+	}
+}
+
+func TestCreatePassesConcurrencyAndEndpoints(t *testing.T) {
+	var seen lockConfig
+	registerBackend("fake", func(c lockConfig) (Lock, error) {
+		seen = c
+		return &fakeLock{}, nil
+	})
+	t.Cleanup(func() { delete(backends, "fake") })
+
+	cfg := &config.AppConfig{
+		LockBackend:    "fake",
+		LockEtcdAddr:   []string{"127.0.0.1:2379"},
+		LockEtcdPrefix: "/storagetapper/",
+	}
+	Create(cfg, 7)
+
+	if seen.Concurrency != 7 {
+		t.Errorf("Concurrency = %v, want 7", seen.Concurrency)
+	}
+	if fmt.Sprint(seen.Endpoints) != fmt.Sprint(cfg.LockEtcdAddr) {
+		t.Errorf("Endpoints = %v, want %v", seen.Endpoints, cfg.LockEtcdAddr)
+	}
+	if seen.Prefix != cfg.LockEtcdPrefix {
+		t.Errorf("Prefix = %v, want %v", seen.Prefix, cfg.LockEtcdPrefix)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package lock
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/uber/storagetapper/log"
+)
+
+// mysqlLock is a lock.Lock backed by MySQL's GET_LOCK/RELEASE_LOCK named
+// locks, pinned to a single connection since the lock is released as soon
+// as the connection holding it closes
+type mysqlLock struct {
+	db   *sql.DB
+	conn *sql.Conn
+	key  string
+}
+
+// newMysqlLock opens addr and pins a single connection to issue
+// GET_LOCK/RELEASE_LOCK on, so the lock survives for the connection's life.
+// enableMDLInstrument gates the one-time, server-wide
+// performance_schema.setup_instruments write Count needs: it's a global
+// toggle, not a session-local setting, so it must not run from every
+// worker's startup path. Operators either flip it once themselves (e.g. via
+// a migration/ops step) or opt every worker into doing it via
+// cfg.LockMysqlEnableMDLInstrument, accepting the redundant writes
+func newMysqlLock(addr string, enableMDLInstrument bool) (Lock, error) {
+	db, err := sql.Open("mysql", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		log.E(db.Close())
+		return nil, err
+	}
+
+	if enableMDLInstrument {
+		_, err = conn.ExecContext(context.Background(), "UPDATE performance_schema.setup_instruments SET ENABLED = 'YES', TIMED = 'YES' WHERE NAME = 'wait/lock/metadata/sql/mdl'")
+		log.E(err)
+	}
+
+	return &mysqlLock{db: db, conn: conn}, nil
+}
+
+// TryLock takes a zero-timeout GET_LOCK on path, returning false immediately
+// if it's already held by another connection
+func (m *mysqlLock) TryLock(path string) bool {
+	var got sql.NullInt64
+	err := m.conn.QueryRowContext(context.Background(), "SELECT GET_LOCK(?, 0)", path).Scan(&got)
+	if log.E(err) || !got.Valid || got.Int64 != 1 {
+		return false
+	}
+	m.key = path
+	return true
+}
+
+// Refresh confirms the lock is still held by this connection
+func (m *mysqlLock) Refresh() bool {
+	var held sql.NullInt64
+	err := m.conn.QueryRowContext(context.Background(), "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", m.key).Scan(&held)
+	return !log.E(err) && held.Valid && held.Int64 == 1
+}
+
+// Unlock releases the named lock held by this connection
+func (m *mysqlLock) Unlock() {
+	var released sql.NullInt64
+	log.E(m.conn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", m.key).Scan(&released))
+}
+
+// Count approximates how many GET_LOCK names under prefix are currently
+// held, by reading performance_schema.metadata_locks, where MySQL also
+// surfaces user-level locks. This relies on the metadata_locks instrument,
+// which is off by default on stock installs; see newMysqlLock's
+// enableMDLInstrument for how deployments turn it on. Without it, this
+// silently returns 0 rather than erroring. OBJECT_NAME is matched as prefix
+// itself or prefix followed by a '.', not a bare string prefix, so a
+// cluster named e.g. "east" doesn't also count locks held by a cluster
+// named "east2"
+func (m *mysqlLock) Count(prefix string) (int, error) {
+	var count int
+	err := m.conn.QueryRowContext(context.Background(),
+		"SELECT COUNT(*) FROM performance_schema.metadata_locks WHERE OBJECT_TYPE = 'USER LEVEL LOCK' AND LOCK_STATUS = 'GRANTED' AND (OBJECT_NAME = ? OR OBJECT_NAME LIKE CONCAT(?, '.%'))", prefix, prefix).Scan(&count)
+	return count, err
+}
+
+// Close releases the pinned connection, which also drops any lock still held
+func (m *mysqlLock) Close() error {
+	err := m.conn.Close()
+	log.E(m.db.Close())
+	return err
+}
+
+func init() {
+	registerBackend("mysql", func(c lockConfig) (Lock, error) {
+		return newMysqlLock(c.Addr, c.EnableMDLInstrument)
+	})
+}
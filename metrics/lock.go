@@ -0,0 +1,46 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	//StreamerLockAttempts counts every table-lock TryLock call lockTable
+	//makes while picking a table to stream; the cluster-lock TryLock calls
+	//that follow a successful table lock are not counted
+	StreamerLockAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "streamer_lock_attempts",
+		Help: "Number of TryLock attempts made by lockTable while selecting a table",
+	})
+
+	//StreamerClusterSaturation reports, per (service, cluster), the
+	//fraction of ClusterConcurrency tickets currently in use, so operators
+	//can tell whether raising ClusterConcurrency would help
+	StreamerClusterSaturation = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "streamer_cluster_saturation",
+		Help: "Fraction of ClusterConcurrency tickets currently held for a cluster",
+	}, []string{"service", "cluster"})
+)
+
+func init() {
+	registry.MustRegister(StreamerLockAttempts)
+	registry.MustRegister(StreamerClusterSaturation)
+}
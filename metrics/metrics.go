@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//Package metrics exposes the Prometheus collectors the Streamer reports
+//through, keyed per-table where a collector needs to come and go with the
+//table's worker
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//registry is where every collector in this package is registered, so a
+//single handler can serve them all
+var registry = prometheus.NewRegistry()
+
+//Streamer holds the per-table gauges tracked for one streamer worker,
+//identified by tag
+type Streamer struct {
+	tag map[string]string
+
+	NumWorkers   prometheus.Gauge
+	BytesWritten prometheus.Gauge
+	BytesRead    prometheus.Gauge
+	//Lag reports how long the streamer has been stalled in waitForGtid
+	//waiting for the snapshot server to catch up
+	Lag prometheus.Gauge
+}
+
+var (
+	streamerMtx sync.Mutex
+	streamers   = make(map[string]*Streamer)
+)
+
+//tagKey turns sTag into a deterministic map key, independent of the order
+//its entries were inserted in
+func tagKey(sTag map[string]string) string {
+	keys := make([]string, 0, len(sTag))
+	for k := range sTag {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(sTag[k])
+		b.WriteByte(';')
+	}
+
+	return b.String()
+}
+
+//GetStreamerMetrics returns the Streamer gauges for sTag, creating and
+//registering them on first use so repeated calls for the same table reuse
+//a single set of collectors
+func GetStreamerMetrics(sTag map[string]string) *Streamer {
+	streamerMtx.Lock()
+	defer streamerMtx.Unlock()
+
+	key := tagKey(sTag)
+
+	s, ok := streamers[key]
+	if ok {
+		return s
+	}
+
+	labels := prometheus.Labels(sTag)
+	s = &Streamer{
+		tag: sTag,
+		NumWorkers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "streamer_num_workers",
+			Help:        "Number of streamer workers currently holding the table's lock",
+			ConstLabels: labels,
+		}),
+		BytesWritten: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "streamer_bytes_written",
+			Help:        "Bytes written to the output pipe by the streamer",
+			ConstLabels: labels,
+		}),
+		BytesRead: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "streamer_bytes_read",
+			Help:        "Bytes read from the input pipe by the streamer",
+			ConstLabels: labels,
+		}),
+		Lag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "streamer_gtid_lag_seconds",
+			Help:        "Seconds the streamer has been waiting for the snapshot server to catch up to the target Gtid",
+			ConstLabels: labels,
+		}),
+	}
+
+	registry.MustRegister(s.NumWorkers, s.BytesWritten, s.BytesRead, s.Lag)
+	streamers[key] = s
+
+	return s
+}
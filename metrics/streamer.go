@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+//CleanStreamerMetrics unregisters the gauges tracked for sTag, so that a
+//worker which crashed or a table that was dropped from state doesn't leave
+//stale series around to confuse dashboards
+func CleanStreamerMetrics(sTag map[string]string) {
+	streamerMtx.Lock()
+	defer streamerMtx.Unlock()
+
+	key := tagKey(sTag)
+
+	s, ok := streamers[key]
+	if !ok {
+		return
+	}
+
+	registry.Unregister(s.NumWorkers)
+	registry.Unregister(s.BytesWritten)
+	registry.Unregister(s.BytesRead)
+	registry.Unregister(s.Lag)
+
+	delete(streamers, key)
+}
+
+//StreamerTags returns the tag sets of every streamer metric currently
+//registered, so callers can reconcile it against live state
+func StreamerTags() []map[string]string {
+	streamerMtx.Lock()
+	defer streamerMtx.Unlock()
+
+	tags := make([]map[string]string, 0, len(streamers))
+	for _, s := range streamers {
+		tags = append(tags, s.tag)
+	}
+
+	return tags
+}
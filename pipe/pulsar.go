@@ -0,0 +1,266 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/uber/storagetapper/config"
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/state"
+)
+
+func init() {
+	registerPlugin("pulsar", initPulsarPipe)
+}
+
+//pulsarPipe implements Pipe on top of an Apache Pulsar client, caching one
+//producer per topic
+type pulsarPipe struct {
+	client pulsar.Client
+
+	mutex     sync.Mutex
+	producers map[string]*pulsarProducer
+}
+
+//pulsarProducer wraps a Pulsar producer for a single topic and partitions
+//messages by the encoded row's primary key
+type pulsarProducer struct {
+	pipe     *pulsarPipe
+	topic    string
+	producer pulsar.Producer
+	format   string
+}
+
+//pulsarMsg is the subset of a pulsar.Message this pipe needs once it's off
+//the wire, decoupled from the pulsar.Message interface so FetchNext/Pop's
+//offset bookkeeping can be tested without a real Pulsar consumer
+type pulsarMsg struct {
+	payload []byte
+	id      pulsar.MessageID
+}
+
+//pulsarConsumer replays messages for a topic starting from a persisted
+//MessageID, so a restarted streamer resumes from the last acknowledged
+//position instead of re-reading the whole topic
+type pulsarConsumer struct {
+	topic      string
+	consumer   pulsar.Consumer
+	cancel     context.CancelFunc
+	msgCh      chan pulsarMsg
+	current    *pulsarMsg
+	pending    *pulsarMsg
+	saveOffset func(topic string, id pulsar.MessageID) error
+	ackID      func(id pulsar.MessageID) error
+}
+
+func initPulsarPipe(cfg *config.AppConfig) (Pipe, error) {
+	client, err := pulsar.NewClient(pulsar.ClientOptions{
+		URL: cfg.PulsarAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pulsarPipe{client: client, producers: make(map[string]*pulsarProducer)}, nil
+}
+
+//Type returns the pipe type name, as used in row.Output and cfg.LockBackend-style dispatch
+func (p *pulsarPipe) Type() string {
+	return "pulsar"
+}
+
+//NewProducer returns a producer for topic, creating and caching the
+//underlying Pulsar producer the first time the topic is requested
+func (p *pulsarPipe) NewProducer(topic string) (Producer, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if pr, ok := p.producers[topic]; ok {
+		return pr, nil
+	}
+
+	prod, err := p.client.CreateProducer(pulsar.ProducerOptions{
+		Topic: topic,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &pulsarProducer{pipe: p, topic: topic, producer: prod}
+	p.producers[topic] = pr
+
+	return pr, nil
+}
+
+//NewConsumer creates a consumer for topic and seeks it to the last
+//acknowledged MessageID persisted in state, if any, so that a restarted
+//streamer resumes from where it left off instead of from the beginning
+func (p *pulsarPipe) NewConsumer(topic string) (Consumer, error) {
+	sub := "storagetapper-" + topic
+
+	cons, err := p.client.Subscribe(pulsar.ConsumerOptions{
+		Topic:            topic,
+		SubscriptionName: sub,
+		Type:             pulsar.Failover,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if id, err := state.GetPulsarOffset(topic); err == nil && id != nil {
+		if err := cons.Seek(id); log.E(err) {
+			log.EL(log.NewTagged(log.Fields{"topic": topic}), err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &pulsarConsumer{
+		topic:      topic,
+		consumer:   cons,
+		cancel:     cancel,
+		msgCh:      make(chan pulsarMsg, 16),
+		saveOffset: state.SavePulsarOffset,
+		ackID:      cons.AckID,
+	}
+
+	go func() {
+		for {
+			msg, err := cons.Receive(ctx)
+			if err != nil {
+				close(c.msgCh)
+				return
+			}
+			select {
+			case c.msgCh <- pulsarMsg{payload: msg.Payload(), id: msg.ID()}:
+			case <-ctx.Done():
+				close(c.msgCh)
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+//SetFormat sets the encoding format of the messages this producer emits
+func (p *pulsarProducer) SetFormat(format string) {
+	p.format = format
+}
+
+//partitionKey derives the Pulsar partition key from the encoded row's
+//primary key, so rows for the same key always land on the same partition
+func partitionKey(key string) string {
+	return key
+}
+
+//Push publishes data without an explicit partition key
+func (p *pulsarProducer) Push(data interface{}) error {
+	return p.PushBatch("", data)
+}
+
+//PushBatch publishes data keyed by key, so that all events sharing a
+//primary key are routed to the same Pulsar partition and stay ordered
+func (p *pulsarProducer) PushBatch(key string, data interface{}) error {
+	buf, ok := data.([]byte)
+	if !ok {
+		return fmt.Errorf("pulsar producer expects []byte payloads, got %T", data)
+	}
+
+	_, err := p.producer.Send(context.Background(), &pulsar.ProducerMessage{
+		Payload:    buf,
+		Key:        partitionKey(key),
+		Properties: map[string]string{"format": p.format},
+	})
+
+	return err
+}
+
+//PushSchema is not applicable to the Pulsar pipe, schema changes are
+//carried as envelope events like with the other pipes
+func (p *pulsarProducer) PushSchema(_ string, _ []byte) error {
+	return nil
+}
+
+//Close closes the underlying Pulsar producer and evicts it from the pipe's
+//cache, so a later NewProducer call for this topic creates a fresh one
+//instead of handing back this now-dead producer
+func (p *pulsarProducer) Close() error {
+	p.producer.Close()
+
+	p.pipe.mutex.Lock()
+	if p.pipe.producers[p.topic] == p {
+		delete(p.pipe.producers, p.topic)
+	}
+	p.pipe.mutex.Unlock()
+
+	return nil
+}
+
+//FetchNext blocks until the next message is available or the consumer is
+//closed, returning false in the latter case. Persists the MessageID of the
+//message handed out by the previous Pop first, since reaching here means
+//the caller has moved on to the next message and so must have finished
+//delivering it downstream. Also acks that MessageID with the broker, since
+//a Failover subscription's own mark-delete position only advances on Ack,
+//independent of the MessageID this pipe persists in state for replay
+func (c *pulsarConsumer) FetchNext() bool {
+	if c.pending != nil {
+		log.E(c.saveOffset(c.topic, c.pending.id))
+		log.E(c.ackID(c.pending.id))
+		c.pending = nil
+	}
+
+	msg, ok := <-c.msgCh
+	if !ok {
+		return false
+	}
+	c.current = &msg
+	return true
+}
+
+//Pop returns the payload of the message fetched by FetchNext. Its
+//MessageID becomes the last acknowledged position once the caller has
+//moved on to the next message, not here, so a crash before this message is
+//actually delivered downstream replays it on restart instead of skipping it
+func (c *pulsarConsumer) Pop() (interface{}, error) {
+	if c.current == nil {
+		return nil, fmt.Errorf("Pop called before FetchNext")
+	}
+	c.pending = c.current
+	return c.current.payload, nil
+}
+
+//Close stops the consumer goroutine and closes the Pulsar subscription
+func (c *pulsarConsumer) Close() error {
+	c.cancel()
+	c.consumer.Close()
+	return nil
+}
+
+//CloseOnFailure closes the consumer without acknowledging the last
+//position, so the next restart replays from the last saved offset
+func (c *pulsarConsumer) CloseOnFailure() error {
+	return c.Close()
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pipe
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+func TestPartitionKey(t *testing.T) {
+	if got := partitionKey("pk-1"); got != "pk-1" {
+		t.Errorf("partitionKey(%q) = %q, want %q", "pk-1", got, "pk-1")
+	}
+}
+
+func TestPulsarProducerPushBatchRejectsNonBytePayload(t *testing.T) {
+	p := &pulsarProducer{topic: "t"}
+
+	if err := p.PushBatch("key", 42); err == nil {
+		t.Errorf("PushBatch with a non-[]byte payload should return an error")
+	}
+}
+
+//fakeMessageID is just enough of a pulsar.MessageID to tell test messages
+//apart
+type fakeMessageID struct{ n int64 }
+
+func (f fakeMessageID) Serialize() []byte   { return []byte{byte(f.n)} }
+func (f fakeMessageID) LedgerID() int64     { return f.n }
+func (f fakeMessageID) EntryID() int64      { return f.n }
+func (f fakeMessageID) BatchIdx() int32     { return 0 }
+func (f fakeMessageID) PartitionIdx() int32 { return 0 }
+func (f fakeMessageID) BatchSize() int32    { return 0 }
+
+//newTestPulsarConsumer returns a pulsarConsumer whose saveOffset/ackID are
+//spies instead of a live state store and Pulsar consumer; saved/acked
+//record the sequence of MessageIDs each was called with
+func newTestPulsarConsumer() (c *pulsarConsumer, saved, acked *[]int64) {
+	saved, acked = &[]int64{}, &[]int64{}
+	c = &pulsarConsumer{
+		topic: "t",
+		msgCh: make(chan pulsarMsg, 16),
+		saveOffset: func(_ string, id pulsar.MessageID) error {
+			*saved = append(*saved, id.(fakeMessageID).n)
+			return nil
+		},
+		ackID: func(id pulsar.MessageID) error {
+			*acked = append(*acked, id.(fakeMessageID).n)
+			return nil
+		},
+	}
+	return c, saved, acked
+}
+
+//TestPulsarConsumerPersistsOffsetOnlyOnNextFetch is a regression test: the
+//MessageID handed out by Pop must not be saved/acked until the caller has
+//moved on to the next message via FetchNext, not from within Pop itself,
+//so a crash before delivery replays rather than skips it
+func TestPulsarConsumerPersistsOffsetOnlyOnNextFetch(t *testing.T) {
+	c, saved, acked := newTestPulsarConsumer()
+	c.msgCh <- pulsarMsg{payload: []byte("one"), id: fakeMessageID{1}}
+	c.msgCh <- pulsarMsg{payload: []byte("two"), id: fakeMessageID{2}}
+
+	if !c.FetchNext() {
+		t.Fatalf("FetchNext returned false on the first message")
+	}
+	payload, err := c.Pop()
+	if err != nil || string(payload.([]byte)) != "one" {
+		t.Fatalf("Pop() = %v, %v, want \"one\", nil", payload, err)
+	}
+
+	if len(*saved) != 0 || len(*acked) != 0 {
+		t.Fatalf("Pop must not persist or ack the offset by itself, got saved=%v acked=%v", *saved, *acked)
+	}
+
+	if !c.FetchNext() {
+		t.Fatalf("FetchNext returned false on the second message")
+	}
+
+	if len(*saved) != 1 || (*saved)[0] != 1 {
+		t.Errorf("FetchNext should have persisted message 1's offset once it moved on, got %v", *saved)
+	}
+	if len(*acked) != 1 || (*acked)[0] != 1 {
+		t.Errorf("FetchNext should have acked message 1's MessageID once it moved on, got %v", *acked)
+	}
+}
+
+//fakeProducer is just enough of a pulsar.Producer for pulsarProducer.Close
+//to exercise
+type fakeProducer struct{ closed bool }
+
+func (f *fakeProducer) Topic() string                      { return "" }
+func (f *fakeProducer) Name() string                       { return "" }
+func (f *fakeProducer) LastSequenceID() int64              { return 0 }
+func (f *fakeProducer) Flush() error                       { return nil }
+func (f *fakeProducer) FlushWithCtx(context.Context) error { return nil }
+func (f *fakeProducer) Close()                             { f.closed = true }
+func (f *fakeProducer) Send(context.Context, *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	return fakeMessageID{}, nil
+}
+func (f *fakeProducer) SendAsync(context.Context, *pulsar.ProducerMessage, func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+}
+
+//TestPulsarProducerCloseEvictsFromPipeCache is a regression test for
+//1cc5286: without eviction, a later NewProducer call for the same topic
+//would hand back this now-closed producer instead of creating a fresh one
+func TestPulsarProducerCloseEvictsFromPipeCache(t *testing.T) {
+	p := &pulsarPipe{producers: make(map[string]*pulsarProducer)}
+	fp := &fakeProducer{}
+	pr := &pulsarProducer{pipe: p, topic: "t", producer: fp}
+	p.producers["t"] = pr
+
+	if err := pr.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !fp.closed {
+		t.Errorf("Close should close the underlying Pulsar producer")
+	}
+	if _, ok := p.producers["t"]; ok {
+		t.Errorf("Close should evict the producer from the pipe's cache so a later NewProducer doesn't hand back this dead producer")
+	}
+}
+
+//TestPulsarProducerCloseDoesNotEvictANewerProducer guards the identity
+//check in Close: if the cache already moved on to a different producer for
+//this topic, closing a stale reference must not evict the new one
+func TestPulsarProducerCloseDoesNotEvictANewerProducer(t *testing.T) {
+	p := &pulsarPipe{producers: make(map[string]*pulsarProducer)}
+	stale := &pulsarProducer{pipe: p, topic: "t", producer: &fakeProducer{}}
+	fresh := &pulsarProducer{pipe: p, topic: "t", producer: &fakeProducer{}}
+	p.producers["t"] = fresh
+
+	if err := stale.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := p.producers["t"]; got != fresh {
+		t.Errorf("Close on a stale producer must not evict a newer one cached under the same topic, got %v", got)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package state
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var (
+	pulsarOffsetOnce sync.Once
+	pulsarOffsetDB   *sql.DB
+	pulsarOffsetErr  error
+)
+
+//pulsarOffsetConn returns the shared pool used for pulsar offset
+//bookkeeping, opened once and reused, since SavePulsarOffset is called on
+//every consumed message
+func pulsarOffsetConn() (*sql.DB, error) {
+	pulsarOffsetOnce.Do(func() {
+		pulsarOffsetDB, pulsarOffsetErr = sql.Open("mysql", GetDbAddr())
+	})
+	return pulsarOffsetDB, pulsarOffsetErr
+}
+
+//GetPulsarOffset returns the last MessageID persisted for topic, or a nil
+//MessageID if none has been saved yet, so a restarted consumer knows
+//whether to subscribe from the beginning or seek to a saved position
+func GetPulsarOffset(topic string) (pulsar.MessageID, error) {
+	conn, err := pulsarOffsetConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	err = conn.QueryRow("SELECT messageId FROM pulsar_offset WHERE topic=?", topic).Scan(&buf)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pulsar.DeserializeMessageID(buf)
+}
+
+//SavePulsarOffset persists id as the last acknowledged MessageID for topic,
+//so a restarted consumer resumes from this position instead of re-reading
+//the whole topic
+func SavePulsarOffset(topic string, id pulsar.MessageID) error {
+	conn, err := pulsarOffsetConn()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec("INSERT INTO pulsar_offset (topic, messageId) VALUES (?, ?) ON DUPLICATE KEY UPDATE messageId=VALUES(messageId)", topic, id.Serialize())
+	return err
+}
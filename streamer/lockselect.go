@@ -0,0 +1,214 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package streamer
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/uber/storagetapper/lock"
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+	"github.com/uber/storagetapper/state"
+)
+
+//clusterGroup is the set of state rows sharing a (Service,Cluster), plus
+//the cluster's remaining lock-ticket capacity as of the last peek
+type clusterGroup struct {
+	idxs      []int
+	remaining int //-1 means capacity is unknown/uncapped
+}
+
+//reservoirOrder returns the indexes of st in the order lockTable should try
+//TryLock-ing them: tables are grouped by cluster, NeedBootstrap rows in a
+//cluster with no remaining ClusterConcurrency tickets are skipped (its
+//ongoing, non-bootstrap rows are still offered), and within the surviving
+//candidates a weighted reservoir sample favors NeedBootstrap rows and rows
+//with an older Gtid. This replaces the old rand.Int()%len(st) linear probe,
+//which kept re-trying (and failing) the same saturated cluster
+func (s *Streamer) reservoirOrder(st state.Type, clusterConcurrency int) []int {
+	groups, order := groupByCluster(st)
+
+	counter, canCount := s.clusterLock.(lock.Counter)
+
+	canPeek := clusterConcurrency != 0 && canCount
+	if canPeek {
+		for _, key := range order {
+			g := groups[key]
+			g.remaining = peekCapacity(counter, key, clusterConcurrency)
+		}
+	}
+
+	var candidates, weights = make([]int, 0, len(st)), make([]float64, 0, len(st))
+	for _, key := range order {
+		g := groups[key]
+
+		idxs := g.idxs
+		//Only NeedBootstrap rows spend a cluster-lock ticket (see lockTable),
+		//so a saturated cluster should only drop those, not its ongoing,
+		//non-bootstrap replication rows
+		if canPeek && g.remaining == 0 {
+			log.Debugf("Cluster %v has no ClusterConcurrency tickets left, skipping its NeedBootstrap rows", key)
+			idxs = filterNeedBootstrap(st, g.idxs, false)
+		}
+
+		rowIdxs, wts := weighTableRows(st, idxs)
+		candidates = append(candidates, rowIdxs...)
+		weights = append(weights, wts...)
+	}
+
+	if len(candidates) == 0 {
+		//Every cluster looked saturated, fall back to trying everything
+		//rather than leaving the worker with nothing to do at all
+		for i := range st {
+			candidates = append(candidates, i)
+			weights = append(weights, 1)
+		}
+	}
+
+	return weightedReservoirOrder(candidates, weights)
+}
+
+//groupByCluster buckets row indexes of st by (Service,Cluster), preserving
+//first-seen group order so metrics/logging stay deterministic across calls
+//with the same state
+func groupByCluster(st state.Type) (map[string]*clusterGroup, []string) {
+	groups := make(map[string]*clusterGroup)
+	order := make([]string, 0)
+
+	for i, row := range st {
+		key := row.Service + "." + row.Cluster
+		g, ok := groups[key]
+		if !ok {
+			g = &clusterGroup{remaining: -1}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.idxs = append(g.idxs, i)
+	}
+
+	return groups, order
+}
+
+//filterNeedBootstrap returns the subset of idxs whose NeedBootstrap flag
+//equals want
+func filterNeedBootstrap(st state.Type, idxs []int, want bool) []int {
+	out := make([]int, 0, len(idxs))
+	for _, idx := range idxs {
+		if st[idx].NeedBootstrap == want {
+			out = append(out, idx)
+		}
+	}
+	return out
+}
+
+//peekCapacity asks the cluster lock backend how many tickets under key are
+//currently held and records streamer_cluster_saturation for it
+func peekCapacity(counter lock.Counter, key string, clusterConcurrency int) int {
+	held, err := counter.Count(key)
+	if log.E(err) {
+		return -1
+	}
+
+	if parts := strings.SplitN(key, ".", 2); len(parts) == 2 {
+		metrics.StreamerClusterSaturation.WithLabelValues(parts[0], parts[1]).Set(float64(held) / float64(clusterConcurrency))
+	}
+
+	return clusterConcurrency - held
+}
+
+//gtidLess reports whether a is an older Gtid than b, i.e. b's set contains
+//everything in a's plus more. Gtid strings like "uuid:1-9" and "uuid:1-10"
+//don't order correctly as plain strings, so this parses them and compares
+//via containment, falling back to a string compare if either fails to
+//parse or the two sets are incomparable
+func gtidLess(a, b string) bool {
+	if a == b {
+		return false
+	}
+
+	as, aerr := mysql.ParseGTIDSet("mysql", a)
+	bs, berr := mysql.ParseGTIDSet("mysql", b)
+	if aerr != nil || berr != nil {
+		return a < b
+	}
+
+	switch {
+	case bs.Contain(as):
+		return true
+	case as.Contain(bs):
+		return false
+	default:
+		return a < b
+	}
+}
+
+//weighTableRows scores each row index in idxs, giving more weight to rows
+//with NeedBootstrap set and to rows with an older Gtid, which are the ones
+//furthest behind
+func weighTableRows(st state.Type, idxs []int) ([]int, []float64) {
+	sorted := append([]int(nil), idxs...)
+	sort.Slice(sorted, func(a, b int) bool { return gtidLess(st[sorted[a]].Gtid, st[sorted[b]].Gtid) })
+
+	weights := make([]float64, len(sorted))
+	for rank, idx := range sorted {
+		w := float64(len(sorted)-rank) + 1
+		if st[idx].NeedBootstrap {
+			w *= 4
+		}
+		weights[rank] = w
+	}
+
+	return sorted, weights
+}
+
+//weightedReservoirOrder implements Efraimidis-Spirakis weighted random
+//sampling without replacement: each candidate gets a key u^(1/w) for a
+//fresh uniform u, and sorting by key descending yields a full priority
+//order where higher-weight items are more likely (but not certain) to
+//come first
+func weightedReservoirOrder(candidates []int, weights []float64) []int {
+	type scored struct {
+		idx int
+		key float64
+	}
+
+	items := make([]scored, len(candidates))
+	for i, idx := range candidates {
+		w := weights[i]
+		if w <= 0 {
+			w = 1e-9
+		}
+		items[i] = scored{idx: idx, key: math.Pow(rand.Float64(), 1/w)}
+	}
+
+	sort.Slice(items, func(a, b int) bool { return items[a].key > items[b].key })
+
+	order := make([]int, len(items))
+	for i, it := range items {
+		order[i] = it.idx
+	}
+
+	return order
+}
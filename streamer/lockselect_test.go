@@ -0,0 +1,182 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package streamer
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/uber/storagetapper/state"
+)
+
+type fakeClusterLock struct {
+	held map[string]int
+}
+
+func (f *fakeClusterLock) TryLock(string) bool { return true }
+func (f *fakeClusterLock) Unlock()             {}
+func (f *fakeClusterLock) Refresh() bool       { return true }
+func (f *fakeClusterLock) Close() error        { return nil }
+func (f *fakeClusterLock) Count(prefix string) (int, error) {
+	return f.held[prefix], nil
+}
+
+func TestFilterNeedBootstrap(t *testing.T) {
+	st := state.Type{
+		{ID: 1, NeedBootstrap: true},
+		{ID: 2, NeedBootstrap: false},
+		{ID: 3, NeedBootstrap: true},
+	}
+
+	if got := filterNeedBootstrap(st, []int{0, 1, 2}, true); !equalInts(got, []int{0, 2}) {
+		t.Errorf("filterNeedBootstrap(want=true) = %v, want [0 2]", got)
+	}
+	if got := filterNeedBootstrap(st, []int{0, 1, 2}, false); !equalInts(got, []int{1}) {
+		t.Errorf("filterNeedBootstrap(want=false) = %v, want [1]", got)
+	}
+}
+
+//TestReservoirOrderKeepsNonBootstrapRowsInSaturatedCluster is a regression
+//test: a saturated cluster must still offer its ongoing (non-bootstrap)
+//rows, since those never spend a cluster-lock ticket in lockTable
+func TestReservoirOrderKeepsNonBootstrapRowsInSaturatedCluster(t *testing.T) {
+	st := state.Type{
+		{ID: 1, Service: "svc", Cluster: "hot", NeedBootstrap: true},
+		{ID: 2, Service: "svc", Cluster: "hot", NeedBootstrap: false},
+		{ID: 3, Service: "svc", Cluster: "cold", NeedBootstrap: false},
+	}
+
+	s := &Streamer{clusterLock: &fakeClusterLock{held: map[string]int{"svc.hot": 1}}}
+
+	order := s.reservoirOrder(st, 1)
+
+	if !containsIdx(order, 1) {
+		t.Errorf("reservoirOrder dropped the non-bootstrap row from a saturated cluster: %v", order)
+	}
+	if containsIdx(order, 0) {
+		t.Errorf("reservoirOrder should have dropped the NeedBootstrap row from the saturated cluster: %v", order)
+	}
+	if !containsIdx(order, 2) {
+		t.Errorf("reservoirOrder dropped a row from an unsaturated cluster: %v", order)
+	}
+}
+
+//TestReservoirOrderDropsBootstrapRowsWhenEveryClusterIsSaturated is a
+//regression test: even when no cluster has room, NeedBootstrap rows must
+//still be shed cluster-by-cluster rather than all being let through, as
+//long as some non-bootstrap row survives (if none does, reservoirOrder
+//falls back to offering every row)
+func TestReservoirOrderDropsBootstrapRowsWhenEveryClusterIsSaturated(t *testing.T) {
+	st := state.Type{
+		{ID: 1, Service: "svc", Cluster: "hot", NeedBootstrap: true},
+		{ID: 2, Service: "svc", Cluster: "hot", NeedBootstrap: false},
+		{ID: 3, Service: "svc", Cluster: "cold", NeedBootstrap: true},
+	}
+
+	s := &Streamer{clusterLock: &fakeClusterLock{held: map[string]int{"svc.hot": 1, "svc.cold": 1}}}
+
+	order := s.reservoirOrder(st, 1)
+
+	if containsIdx(order, 0) || containsIdx(order, 2) {
+		t.Errorf("reservoirOrder should drop NeedBootstrap rows from every saturated cluster: %v", order)
+	}
+	if !containsIdx(order, 1) {
+		t.Errorf("reservoirOrder dropped the non-bootstrap row from a saturated cluster: %v", order)
+	}
+}
+
+func TestReservoirOrderIsAPermutation(t *testing.T) {
+	st := state.Type{
+		{ID: 1, Service: "svc", Cluster: "a"},
+		{ID: 2, Service: "svc", Cluster: "a"},
+		{ID: 3, Service: "svc", Cluster: "b"},
+	}
+
+	s := &Streamer{}
+	order := s.reservoirOrder(st, 0)
+
+	if len(order) != len(st) {
+		t.Fatalf("reservoirOrder returned %d indexes, want %d", len(order), len(st))
+	}
+	sorted := append([]int(nil), order...)
+	sort.Ints(sorted)
+	if !equalInts(sorted, []int{0, 1, 2}) {
+		t.Errorf("reservoirOrder is not a permutation of 0..len(st): %v", order)
+	}
+}
+
+func TestWeighTableRowsFavorsBootstrapAndOlderGtid(t *testing.T) {
+	st := state.Type{
+		{ID: 1, Gtid: "b", NeedBootstrap: false},
+		{ID: 2, Gtid: "a", NeedBootstrap: true},
+	}
+
+	idxs, weights := weighTableRows(st, []int{0, 1})
+
+	if idxs[0] != 1 {
+		t.Fatalf("weighTableRows should sort by Gtid first, got order %v", idxs)
+	}
+	if weights[0] <= weights[1] {
+		t.Errorf("the NeedBootstrap, older-Gtid row should outweigh the other: %v", weights)
+	}
+}
+
+//TestGtidLessComparesGtidSetsByContainment is a regression test for the
+//bug gtidLess was introduced to fix: "uuid:1-9" and "uuid:1-10" differ
+//only in range length, so a plain string compare orders them backwards
+//("uuid:1-10" < "uuid:1-9"), the opposite of GTID-set containment
+func TestGtidLessComparesGtidSetsByContainment(t *testing.T) {
+	uuid := "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	older := uuid + ":1-9"
+	newer := uuid + ":1-10"
+
+	if newer >= older {
+		t.Fatalf("test fixture assumption broken: expected %q to lexicographically precede %q", newer, older)
+	}
+
+	if !gtidLess(older, newer) {
+		t.Errorf("gtidLess(%q, %q) = false, want true", older, newer)
+	}
+	if gtidLess(newer, older) {
+		t.Errorf("gtidLess(%q, %q) = true, want false", newer, older)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsIdx(xs []int, v int) bool {
+	for _, x := range xs {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -22,7 +22,7 @@ package streamer
 
 import (
 	"fmt"
-	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/siddontang/go-mysql/mysql"
@@ -97,11 +97,13 @@ func (s *Streamer) ensureBinlogReaderStart() (string, error) {
 	}
 }
 
-func (s *Streamer) waitForGtid(svc string, sdb string, gtid string) bool {
+func (s *Streamer) waitForGtid(cfg *config.AppConfig, svc string, sdb string, gtid string) bool {
 	var current mysql.GTIDSet
 
 	log.Debugf("Waiting for snapshot server to catch up to: %v", gtid)
 
+	targetGtid := gtid
+
 	target, err := mysql.ParseGTIDSet("mysql", gtid)
 	if log.EL(s.log, err) {
 		return false
@@ -113,8 +115,14 @@ func (s *Streamer) waitForGtid(svc string, sdb string, gtid string) bool {
 	}
 	defer func() { log.EL(s.log, conn.Close()) }()
 
+	started := time.Now()
+
 	tickCheck := time.NewTicker(3 * time.Second).C
 	tickLock := time.NewTicker(s.stateUpdateTimeout).C
+	var tickHeartbeat <-chan time.Time
+	if cfg.HeartbeatInterval > 0 {
+		tickHeartbeat = time.NewTicker(cfg.HeartbeatInterval).C
+	}
 	for {
 		err = conn.QueryRow("SELECT @@global.gtid_executed").Scan(&gtid)
 		if log.EL(s.log, err) {
@@ -137,23 +145,43 @@ func (s *Streamer) waitForGtid(svc string, sdb string, gtid string) bool {
 				s.log.Debugf("Lost the lock while waiting for gtid")
 				return false
 			}
+		case <-tickHeartbeat:
+			s.sendHeartbeat(gtid, targetGtid, started)
 		case <-shutdown.InitiatedCh():
 			return false
 		default:
 		}
 	}
 
+	s.metrics.Lag.Set(0)
+
 	log.Debugf("Snapshot server at: %v", current)
 
 	return true
 }
 
+//sendHeartbeat emits a heartbeat envelope event so downstream consumers can
+//tell "alive but waiting for the snapshot server to catch up" from a dead
+//streamer during a long waitForGtid stall, and updates the gtid lag gauge
+func (s *Streamer) sendHeartbeat(currentGtid string, targetGtid string, started time.Time) {
+	msg, err := s.envEncoder.EncodeHeartbeat(currentGtid, targetGtid, s.version, time.Now())
+	if log.EL(s.log, err) {
+		return
+	}
+	log.EL(s.log, s.outProducer.Push(msg))
+
+	s.metrics.Lag.Set(time.Since(started).Seconds())
+}
+
 func (s *Streamer) lockTable(st state.Type, outPipes *map[string]pipe.Pipe, clusterConcurrency int) {
 	if len(st) == 0 {
 		return
 	}
-	for pos, j := rand.Int()%len(st), 0; j < len(st); j++ {
+
+	for _, pos := range s.reservoirOrder(st, clusterConcurrency) {
 		row := st[pos]
+
+		metrics.StreamerLockAttempts.Inc()
 		if s.tableLock.TryLock(fmt.Sprintf("table_id.%d", row.ID)) {
 			//If cluster concurrency is limited, try to get our ticket
 			if clusterConcurrency != 0 && row.NeedBootstrap {
@@ -181,10 +209,16 @@ func (s *Streamer) lockTable(st state.Type, outPipes *map[string]pipe.Pipe, clus
 			s.outputFormat = row.OutputFormat
 			break
 		}
-		pos = (pos + 1) % len(st)
 	}
 }
 
+//getTag returns the metrics tag identifying this streamer's table, used to
+//key its metrics.Streamer instance and to find it again from
+//reconcileStaleMetrics
+func (s *Streamer) getTag() map[string]string {
+	return map[string]string{"svc": s.svc, "db": s.db, "table": s.table}
+}
+
 func readState(cfg *config.AppConfig) (state.Type, error) {
 	if cfg.ChangelogPipeType == "local" {
 		return state.GetForCluster(changelog.ThisInstanceCluster())
@@ -205,10 +239,10 @@ func (s *Streamer) start(cfg *config.AppConfig, outPipes *map[string]pipe.Pipe)
 		log.Errorf("Error reading state: %v", err.Error())
 	}
 
-	s.tableLock = lock.Create(state.GetDbAddr(), cfg.OutputPipeConcurrency)
+	s.tableLock = lock.Create(cfg, cfg.OutputPipeConcurrency)
 	defer s.tableLock.Close()
 	if cfg.ClusterConcurrency != 0 {
-		s.clusterLock = lock.Create(state.GetDbAddr(), cfg.ClusterConcurrency)
+		s.clusterLock = lock.Create(cfg, cfg.ClusterConcurrency)
 		defer s.clusterLock.Close()
 	}
 
@@ -221,6 +255,8 @@ func (s *Streamer) start(cfg *config.AppConfig, outPipes *map[string]pipe.Pipe)
 	}
 
 	sTag := s.getTag()
+	defer metrics.CleanStreamerMetrics(sTag)
+
 	s.metrics = metrics.GetStreamerMetrics(sTag)
 	log.Debugf("Initializing metrics for streamer: Cluster: %s, DB: %s, Table: %s -- Tags: %v",
 		s.cluster, s.db, s.table, sTag)
@@ -249,6 +285,14 @@ func (s *Streamer) start(cfg *config.AppConfig, outPipes *map[string]pipe.Pipe)
 
 	s.outProducer.SetFormat(s.outputFormat)
 
+	//Transit format encoder, aka envelope encoder
+	//It must be per table to be able to decode schematized events
+	//Created before waitForGtid, which may call sendHeartbeat on this encoder
+	s.envEncoder, err = encoder.Create(encoder.Internal.Type(), s.svc, s.db, s.table, s.version)
+	if log.EL(s.log, err) {
+		return false
+	}
+
 	// Ensures that some binlog reader worker has started reading log events for the cluster on
 	// which the table resides.
 	gtid, err := s.ensureBinlogReaderStart()
@@ -256,18 +300,11 @@ func (s *Streamer) start(cfg *config.AppConfig, outPipes *map[string]pipe.Pipe)
 		return false
 	}
 
-	if !s.waitForGtid(s.svc, s.db, gtid) {
+	if !s.waitForGtid(cfg, s.svc, s.db, gtid) {
 		return false
 	}
 
-	s.outEncoder, err = encoder.Create(s.outputFormat, s.svc, s.db, s.table)
-	if log.EL(s.log, err) {
-		return false
-	}
-
-	//Transit format encoder, aka envelope encoder
-	//It must be per table to be able to decode schematized events
-	s.envEncoder, err = encoder.Create(encoder.Internal.Type(), s.svc, s.db, s.table)
+	s.outEncoder, err = encoder.Create(s.outputFormat, s.svc, s.db, s.table, s.version)
 	if log.EL(s.log, err) {
 		return false
 	}
@@ -296,7 +333,7 @@ func (s *Streamer) start(cfg *config.AppConfig, outPipes *map[string]pipe.Pipe)
 	}
 
 	if cfg.ChangelogBuffer {
-		s.StreamTable(consumer)
+		s.streamWithSchemaEvolution(cfg, consumer)
 	}
 
 	log.Debugf("Finished streamer")
@@ -304,8 +341,48 @@ func (s *Streamer) start(cfg *config.AppConfig, outPipes *map[string]pipe.Pipe)
 	return true
 }
 
+var startMetricsReconcilerOnce sync.Once
+
+//metricsReconcileInterval is how often the Worker driver walks the metrics
+//registry looking for tags whose table is no longer present in state,
+//catching crashes that skip the deferred cleanup in start
+const metricsReconcileInterval = 60 * time.Second
+
+//startMetricsReconciler launches the periodic metrics reconciliation loop
+//once per process, regardless of how many times Worker is called
+func startMetricsReconciler(cfg *config.AppConfig) {
+	startMetricsReconcilerOnce.Do(func() {
+		go func() {
+			tick := time.NewTicker(metricsReconcileInterval).C
+			for {
+				select {
+				case <-tick:
+					reconcileStaleMetrics()
+				case <-shutdown.InitiatedCh():
+					return
+				}
+			}
+		}()
+	})
+}
+
+//reconcileStaleMetrics drops gauges for any streamer tag whose table is no
+//longer present in state
+func reconcileStaleMetrics() {
+	for _, tag := range metrics.StreamerTags() {
+		rows, err := state.GetCond("svc=? AND db=? AND tableName=?", tag["svc"], tag["db"], tag["table"])
+		if log.E(err) {
+			continue
+		}
+		if len(rows) == 0 {
+			metrics.CleanStreamerMetrics(tag)
+		}
+	}
+}
+
 // Worker : Initializer function
 func Worker(cfg *config.AppConfig, inP pipe.Pipe, outPipes *map[string]pipe.Pipe) bool {
+	startMetricsReconciler(cfg)
 	s := &Streamer{inPipe: inP}
 	return s.start(cfg, outPipes)
 }
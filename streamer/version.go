@@ -0,0 +1,150 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package streamer
+
+import (
+	"time"
+
+	"github.com/uber/storagetapper/config"
+	"github.com/uber/storagetapper/encoder"
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/pipe"
+	"github.com/uber/storagetapper/state"
+)
+
+//versionCheckInterval is how often the running streamer polls state for an
+//online DDL having bumped the table's Version. A var, not a const, so
+//tests can shrink it instead of waiting out the real interval
+var versionCheckInterval = 10 * time.Second
+
+//getTableByID is state.GetTableByID through a package var, so tests can
+//substitute a fake without a live state store
+var getTableByID = state.GetTableByID
+
+//streamWithSchemaEvolution runs StreamTable and restarts it under a fresh
+//encoder/topic whenever a concurrent DDL bumps the table's Version, so a
+//worker doesn't need to be restarted to pick up schema changes mid-stream
+func (s *Streamer) streamWithSchemaEvolution(cfg *config.AppConfig, consumer pipe.Consumer) {
+	for {
+		stop := make(chan struct{})
+		newVersion := make(chan int, 1)
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			s.watchVersion(stop, newVersion, consumer)
+		}()
+
+		s.StreamTable(consumer)
+
+		close(stop)
+		//Wait for watchVersion to actually exit before inspecting newVersion,
+		//otherwise a ticker fire racing with close(stop) can land a value on
+		//newVersion (and close consumer) after we've already taken the
+		//default branch below
+		<-done
+
+		select {
+		case v := <-newVersion:
+			s.log.WithFields(log.Fields{"from": s.version, "to": v}).Debugf("Table version changed, switching encoder and topic")
+
+			nc, err := s.switchVersion(cfg, v)
+			if log.EL(s.log, err) {
+				return
+			}
+			consumer = nc
+		default:
+			//StreamTable returned for a reason other than a version bump
+			//(shutdown, consumer drained, error), nothing left to do
+			return
+		}
+	}
+}
+
+//watchVersion polls state.GetTableByID for s.id until it observes a Version
+//different from s.version, emits a schema-change marker through the
+//envelope encoder, and closes consumer so StreamTable unwinds and
+//streamWithSchemaEvolution can pick up the new version
+func (s *Streamer) watchVersion(stop <-chan struct{}, newVersion chan<- int, consumer pipe.Consumer) {
+	tick := time.NewTicker(versionCheckInterval).C
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+			sRows, err := getTableByID(s.id)
+			if log.EL(s.log, err) || len(sRows) == 0 {
+				continue
+			}
+			if sRows[0].Version == s.version {
+				continue
+			}
+
+			if msg, err := s.envEncoder.EncodeSchemaChange(s.version, sRows[0].Version); !log.EL(s.log, err) {
+				log.EL(s.log, s.outProducer.Push(msg))
+			}
+
+			newVersion <- sRows[0].Version
+			log.EL(s.log, consumer.CloseOnFailure())
+			return
+		}
+	}
+}
+
+//switchVersion closes the producer for the table's current version and
+//reopens the producer, both encoders and the changelog consumer against
+//the new version's topics so consumers can tell old and new schema events
+//apart. It does not drain the old version's changelog consumer, which
+//watchVersion has already closed with CloseOnFailure
+func (s *Streamer) switchVersion(cfg *config.AppConfig, newVersion int) (pipe.Consumer, error) {
+	log.EL(s.log, s.outProducer.Close())
+
+	s.version = newVersion
+
+	var err error
+	s.topic, err = cfg.GetOutputTopicName(s.svc, s.db, s.table, s.input, s.output, s.version)
+	if err != nil {
+		return nil, err
+	}
+
+	s.outProducer, err = s.outPipe.NewProducer(s.topic)
+	if err != nil {
+		return nil, err
+	}
+	s.outProducer.SetFormat(s.outputFormat)
+
+	s.outEncoder, err = encoder.Create(s.outputFormat, s.svc, s.db, s.table, s.version)
+	if err != nil {
+		return nil, err
+	}
+
+	s.envEncoder, err = encoder.Create(encoder.Internal.Type(), s.svc, s.db, s.table, s.version)
+	if err != nil {
+		return nil, err
+	}
+
+	tn, err := config.Get().GetChangelogTopicName(s.svc, s.db, s.table, s.input, s.output, s.version)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.inPipe.NewConsumer(tn)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package streamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/storagetapper/encoder"
+	"github.com/uber/storagetapper/state"
+)
+
+type fakeVersionConsumer struct {
+	closedOnFailure bool
+}
+
+func (f *fakeVersionConsumer) FetchNext() bool           { return false }
+func (f *fakeVersionConsumer) Pop() (interface{}, error) { return nil, nil }
+func (f *fakeVersionConsumer) Close() error              { return nil }
+func (f *fakeVersionConsumer) CloseOnFailure() error {
+	f.closedOnFailure = true
+	return nil
+}
+
+type fakeVersionProducer struct {
+	pushed [][]byte
+}
+
+func (f *fakeVersionProducer) SetFormat(string)            {}
+func (f *fakeVersionProducer) Push(data interface{}) error { return f.PushBatch("", data) }
+func (f *fakeVersionProducer) PushBatch(_ string, data interface{}) error {
+	f.pushed = append(f.pushed, data.([]byte))
+	return nil
+}
+func (f *fakeVersionProducer) PushSchema(_ string, _ []byte) error { return nil }
+func (f *fakeVersionProducer) Close() error                        { return nil }
+
+//TestWatchVersionEmitsSchemaChangeAndSignalsNewVersion is a regression test
+//for the goroutine/channel handoff between watchVersion and
+//streamWithSchemaEvolution: once state reports a new Version, watchVersion
+//must push a schema-change marker, signal newVersion, and close the
+//consumer on failure so StreamTable unwinds
+func TestWatchVersionEmitsSchemaChangeAndSignalsNewVersion(t *testing.T) {
+	origGetTableByID := getTableByID
+	origInterval := versionCheckInterval
+	defer func() { getTableByID = origGetTableByID; versionCheckInterval = origInterval }()
+
+	versionCheckInterval = time.Millisecond
+	getTableByID = func(id int64) (state.Type, error) {
+		return state.Type{{ID: id, Version: 2}}, nil
+	}
+
+	enc, err := encoder.Create(encoder.Internal.Type(), "svc", "db", "t", 1)
+	if err != nil {
+		t.Fatalf("encoder.Create: %v", err)
+	}
+
+	producer := &fakeVersionProducer{}
+	consumer := &fakeVersionConsumer{}
+
+	s := &Streamer{svc: "svc", db: "db", table: "t", version: 1, id: 7, outProducer: producer, envEncoder: enc}
+
+	stop := make(chan struct{})
+	newVersion := make(chan int, 1)
+
+	done := make(chan int)
+	go func() {
+		s.watchVersion(stop, newVersion, consumer)
+		v := <-newVersion
+		done <- v
+	}()
+
+	select {
+	case v := <-done:
+		if v != 2 {
+			t.Errorf("watchVersion signaled version %d, want 2", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watchVersion did not signal a new version in time")
+	}
+
+	if len(producer.pushed) != 1 {
+		t.Fatalf("watchVersion pushed %d messages, want 1", len(producer.pushed))
+	}
+	if !consumer.closedOnFailure {
+		t.Error("watchVersion should have closed the consumer on failure so StreamTable unwinds")
+	}
+}
+
+//TestWatchVersionStopsWithoutChange confirms watchVersion exits cleanly via
+//stop when state never reports a version change
+func TestWatchVersionStopsWithoutChange(t *testing.T) {
+	origGetTableByID := getTableByID
+	origInterval := versionCheckInterval
+	defer func() { getTableByID = origGetTableByID; versionCheckInterval = origInterval }()
+
+	versionCheckInterval = time.Millisecond
+	getTableByID = func(id int64) (state.Type, error) {
+		return state.Type{{ID: id, Version: 1}}, nil
+	}
+
+	enc, err := encoder.Create(encoder.Internal.Type(), "svc", "db", "t", 1)
+	if err != nil {
+		t.Fatalf("encoder.Create: %v", err)
+	}
+
+	s := &Streamer{svc: "svc", db: "db", table: "t", version: 1, id: 7, outProducer: &fakeVersionProducer{}, envEncoder: enc}
+
+	stop := make(chan struct{})
+	newVersion := make(chan int, 1)
+
+	done := make(chan struct{})
+	go func() {
+		s.watchVersion(stop, newVersion, &fakeVersionConsumer{})
+		close(done)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchVersion did not return after stop was closed")
+	}
+}